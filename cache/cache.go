@@ -0,0 +1,120 @@
+// Package cache implements a block-oriented LRU read cache for remote
+// files, so a FileServer can serve repeated/partial reads of a file it
+// does not hold locally without re-pulling the whole thing over the
+// network every time.
+package cache
+
+import (
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// BlockSize is the granularity at which remote reads are fetched and
+// cached. Reads are split on BlockSize-aligned boundaries so that two
+// overlapping reads share cached blocks instead of duplicating work.
+const BlockSize int64 = 1 << 20 // 1 MiB
+
+// FetchFunc retrieves length bytes of the remote file starting at offset.
+// It is called at most once per (file, block) pair until that block is
+// evicted from the cache.
+type FetchFunc func(offset, length int64) ([]byte, error)
+
+// CacheBlock holds one BlockSize-aligned slice of a remote file. Its own
+// mutex lets concurrent readers of the same block wait on a single fetch
+// instead of issuing redundant network requests.
+type CacheBlock struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// CachedFile fronts a single remote file with an LRU of its blocks. Size
+// limits are expressed as a count of cached blocks rather than raw bytes,
+// which keeps eviction cheap while still bounding memory.
+type CachedFile struct {
+	fileSize int64
+	fetch    FetchFunc
+
+	blocks *lru.Cache[int64, *CacheBlock]
+}
+
+// NewCachedFile builds a CachedFile for a remote file of fileSize bytes.
+// perFileBlocks caps how many blocks of this file may be cached at once;
+// fetch is invoked on a cache miss to pull a block from the network.
+func NewCachedFile(fileSize int64, perFileBlocks int, fetch FetchFunc) (*CachedFile, error) {
+	blocks, err := lru.New[int64, *CacheBlock](perFileBlocks)
+	if err != nil {
+		return nil, err
+	}
+	return &CachedFile{
+		fileSize: fileSize,
+		fetch:    fetch,
+		blocks:   blocks,
+	}, nil
+}
+
+// FileSize returns the remote file's total size, as given to NewCachedFile.
+func (c *CachedFile) FileSize() int64 { return c.fileSize }
+
+// ReadAt returns the length bytes starting at offset, fetching and caching
+// whichever blocks are not already present.
+func (c *CachedFile) ReadAt(offset, length int64) ([]byte, error) {
+	if offset+length > c.fileSize {
+		length = c.fileSize - offset
+	}
+
+	out := make([]byte, 0, length)
+	for int64(len(out)) < length {
+		blockStart := (offset + int64(len(out))) / BlockSize * BlockSize
+		block, err := c.getBlock(blockStart)
+		if err != nil {
+			return nil, err
+		}
+
+		blockOffset := offset + int64(len(out)) - blockStart
+		want := length - int64(len(out))
+		have := int64(len(block.data)) - blockOffset
+		if have < want {
+			want = have
+		}
+		out = append(out, block.data[blockOffset:blockOffset+want]...)
+	}
+
+	return out, nil
+}
+
+// getBlock returns the block starting at blockStart, fetching it on a miss.
+// The per-block mutex is held across the fetch so concurrent readers of the
+// same block collapse onto a single network round trip.
+func (c *CachedFile) getBlock(blockStart int64) (*CacheBlock, error) {
+	// Get-then-Add is a check-then-act race: two goroutines missing the
+	// same block concurrently would each build and insert their own
+	// CacheBlock and fetch independently. PeekOrAdd does the check and
+	// the insert as one atomic step, so only one CacheBlock per
+	// blockStart is ever created.
+	candidate := &CacheBlock{}
+	existing, ok, _ := c.blocks.PeekOrAdd(blockStart, candidate)
+	block := candidate
+	if ok {
+		block = existing
+	}
+
+	block.mu.Lock()
+	defer block.mu.Unlock()
+
+	if block.data != nil {
+		return block, nil
+	}
+
+	length := BlockSize
+	if remaining := c.fileSize - blockStart; remaining < length {
+		length = remaining
+	}
+
+	data, err := c.fetch(blockStart, length)
+	if err != nil {
+		return nil, err
+	}
+	block.data = data
+	return block, nil
+}
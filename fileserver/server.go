@@ -4,30 +4,157 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"strconv"
 	"sync"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ranjankuldeep/distributed_file_system/cache"
 	"github.com/ranjankuldeep/distributed_file_system/logs"
+	"github.com/ranjankuldeep/distributed_file_system/metrics"
+	"github.com/ranjankuldeep/distributed_file_system/nat"
 	"github.com/ranjankuldeep/distributed_file_system/p2p"
+	"github.com/ranjankuldeep/distributed_file_system/selector"
 	"github.com/ranjankuldeep/distributed_file_system/store"
 )
 
+// defaultPerFileCacheBlocks/defaultTotalCacheFiles bound the block cache
+// when a FileServerOpts leaves them unset: 16 blocks (16 MiB) per file,
+// across up to 64 distinct remote files.
+const (
+	defaultPerFileCacheBlocks = 16
+	defaultTotalCacheFiles    = 64
+)
+
+// defaultReplicationFactor is how many peers a key is stored on/fetched
+// from when FileServerOpts.ReplicationFactor is left unset.
+const defaultReplicationFactor = 3
+
+// getFileResponseTimeout bounds how long requestRange waits for a single
+// targeted peer to answer before moving on to the next one.
+const getFileResponseTimeout = time.Millisecond * 500
+
+// handshakeTimeout bounds how long a connected peer has to complete a
+// handshake before it is dropped as unresponsive.
+const handshakeTimeout = time.Second * 5
+
+// getFileLengthProbe is the MessageGetFile.Length sentinel meaning "just
+// tell me the file size, do not stream any data".
+const getFileLengthProbe = -1
+
+// ProtocolVersion is the filesystem/base subprotocol version this build
+// speaks, advertised in HandshakeMessage. A peer advertising a different
+// version is rejected rather than risk misinterpreting its messages.
+const ProtocolVersion uint32 = 1
+
 type FileServerOpts struct {
 	ID                string
 	StorageRoot       string
 	PathTransformFunc store.PathTransformFunc
 	Transport         p2p.Transport
 	BootStrapNodes    []string
+
+	// NodeID identifies this node to peers across reconnects. Left
+	// unset, a random one is generated.
+	NodeID p2p.NodeID
+	// Caps lists the optional capabilities this node supports,
+	// advertised to peers in its handshake.
+	Caps []p2p.Cap
+
+	// PerFileCacheBlocks caps how many 1 MiB blocks of a single remote file
+	// may be cached at once. Defaults to defaultPerFileCacheBlocks.
+	PerFileCacheBlocks int
+	// TotalCacheSize caps how many distinct remote files may have a block
+	// cache resident at once. Defaults to defaultTotalCacheFiles.
+	TotalCacheSize int
+
+	// ReplicationFactor is how many peers Store streams a file to and Get
+	// queries for it, instead of broadcasting to the whole network.
+	// Defaults to defaultReplicationFactor.
+	ReplicationFactor int
+	// PeerSelector ranks peers for a key. Defaults to a consistent-hash
+	// ring over connected peer IDs.
+	PeerSelector selector.PeerSelector
+
+	// Reporter, if set, receives counters/timings for peer byte counts,
+	// message codes, cache hit/miss, and Store/Get timings. Left nil,
+	// metrics are simply not collected.
+	Reporter metrics.Reporter
+
+	// NAT, if set, is used to map ListenPort on the gateway so peers
+	// behind NAT can still be dialed. Left nil, no port mapping is
+	// attempted.
+	NAT nat.Interface
+	// ListenPort is the port NAT should map to this host. Required for
+	// NAT to have any effect; ignored otherwise.
+	ListenPort int
 }
 type FileServer struct {
 	FileServerOpts
-	store  *store.Store
-	quitch chan struct{}
+	store    *store.Store
+	quitch   chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+	selector selector.PeerSelector
+
+	// externalAddr is the ip:port NAT has most recently mapped us at, if
+	// NAT is configured; announced to peers via HandshakeMessage.
+	externalAddr string
 
 	peerLock sync.Mutex
-	peers    map[string]p2p.Peer
+	// conns holds every connected peer, keyed by the remote address the
+	// transport reports for it (RPC.From), regardless of whether it has
+	// completed a handshake yet. This is what routes an inbound message
+	// back to the p2p.Peer it arrived on.
+	conns map[string]p2p.Peer
+	// peers holds only peers that have completed a valid handshake,
+	// keyed by their NodeID rather than address so a reconnect (a new
+	// connection, same logical node) replaces its old entry instead of
+	// accumulating a stale one. Store/Get/BroadCast only ever target
+	// these peers.
+	peers map[string]p2p.Peer
+	// peerListenAddrs holds the NAT-mapped ip:port each peer announced in
+	// its HandshakeMessage, keyed the same way as peers. This is what a
+	// future reconnect would dial instead of the address the connection
+	// happened to arrive from.
+	peerListenAddrs map[string]string
+
+	// fileCaches bounds the set of remote files we keep a block cache for;
+	// evicting a CachedFile here just drops the cached bytes, the remote
+	// copy is unaffected.
+	fileCaches *lru.Cache[string, *cache.CachedFile]
+
+	// getWaiters routes an inbound MessageGetFileResponse back to the
+	// requestRange call waiting on it, keyed by getWaitKey(key, peerID).
+	getWaitersLock sync.Mutex
+	getWaiters     map[string]chan getFileResult
+
+	// protocols holds every code range registered via RegisterProtocol,
+	// including the server's own base protocol registered in
+	// NewFileServer. nextCode is the first code not yet handed out.
+	protocolsLock sync.Mutex
+	protocols     []protocolHandler
+	nextCode      uint64
+}
+
+type protocolHandler struct {
+	name    string
+	base    uint64
+	codes   uint64
+	handler func(p2p.Peer, p2p.Msg) error
+}
+
+type getFileResult struct {
+	found bool
+	size  int64
+}
+
+func getWaitKey(key, peerID string) string {
+	return key + "|" + peerID
 }
 
 // Message that is wired over.
@@ -42,9 +169,40 @@ type MessageStoreFile struct {
 	Size int64
 }
 
+// MessageGetFile requests the [Offset, Offset+Length) byte range of Key.
+// Length == 0 means "the rest of the file" (and, with Offset == 0, "the
+// whole file") for backwards compatibility; Length == getFileLengthProbe
+// means "tell me the file size but do not stream any bytes".
 type MessageGetFile struct {
-	ID  string
-	Key string
+	ID     string
+	Key    string
+	Offset int64
+	Length int64
+}
+
+// MessageGetFileResponse is the control reply a peer sends immediately
+// after a MessageGetFile, so the requester can stop waiting on peers that
+// don't have the key instead of guessing with a fixed sleep. When Found is
+// true and the requested range is non-empty, the replying peer immediately
+// follows this message with that byte range as a raw stream; a size probe
+// or a range that clips to nothing gets no stream at all.
+type MessageGetFileResponse struct {
+	Key   string
+	Found bool
+	Size  int64
+}
+
+// HandshakeMessage identifies a node to the peer it just connected to,
+// sent once right after OnPeer over the framed Msg protocol, before any
+// Store/Get may target that peer. ListenAddr announces the NAT-mapped
+// external address the peer should dial to reach us, so other peers dial
+// that rather than whatever address they happened to see the connection
+// come from. It is empty when no NAT mapping has succeeded.
+type HandshakeMessage struct {
+	NodeID          p2p.NodeID
+	ProtocolVersion uint32
+	Caps            []p2p.Cap
+	ListenAddr      string
 }
 
 func NewFileServer(opts FileServerOpts) *FileServer {
@@ -55,13 +213,151 @@ func NewFileServer(opts FileServerOpts) *FileServer {
 	if len(opts.ID) == 0 {
 		opts.ID = "1234"
 	}
-	return &FileServer{
-		FileServerOpts: opts,
-		store:          store.NewStore(storeOpts),
-		quitch:         make(chan struct{}),
-		peers:          make(map[string]p2p.Peer),
-		peerLock:       sync.Mutex{},
+	if opts.PerFileCacheBlocks == 0 {
+		opts.PerFileCacheBlocks = defaultPerFileCacheBlocks
+	}
+	if opts.TotalCacheSize == 0 {
+		opts.TotalCacheSize = defaultTotalCacheFiles
+	}
+	if opts.ReplicationFactor == 0 {
+		opts.ReplicationFactor = defaultReplicationFactor
+	}
+	if opts.PeerSelector == nil {
+		opts.PeerSelector = selector.NewConsistentHash()
+	}
+	if len(opts.NodeID) == 0 {
+		opts.NodeID = p2p.NewNodeID()
+	}
+
+	fileCaches, err := lru.New[string, *cache.CachedFile](opts.TotalCacheSize)
+	if err != nil {
+		panic(err) // only returns an error for a non-positive size
+	}
+
+	fs := &FileServer{
+		FileServerOpts:  opts,
+		store:           store.NewStore(storeOpts),
+		quitch:          make(chan struct{}),
+		stopped:         make(chan struct{}),
+		selector:        opts.PeerSelector,
+		conns:           make(map[string]p2p.Peer),
+		peers:           make(map[string]p2p.Peer),
+		peerListenAddrs: make(map[string]string),
+		peerLock:        sync.Mutex{},
+		fileCaches:      fileCaches,
+		getWaiters:      make(map[string]chan getFileResult),
+	}
+
+	// Reserve the base protocol's code range first so extension
+	// subprotocols registered later never collide with it.
+	if _, err := fs.RegisterProtocol("filesystem/base", p2p.BaseProtocolLength, fs.handleBaseMsg); err != nil {
+		panic(err) // RegisterProtocol currently never returns an error
+	}
+
+	return fs
+}
+
+// RegisterProtocol reserves the next `codes` message codes for name and
+// routes any inbound Msg whose Code falls in that range to handler. This
+// lets extension subprotocols add message kinds without touching
+// FileServer's core dispatch or the gob.Register table the legacy path
+// still relies on.
+func (fs *FileServer) RegisterProtocol(name string, codes uint64, handler func(p2p.Peer, p2p.Msg) error) (baseCode uint64, err error) {
+	fs.protocolsLock.Lock()
+	defer fs.protocolsLock.Unlock()
+
+	base := fs.nextCode
+	fs.protocols = append(fs.protocols, protocolHandler{name: name, base: base, codes: codes, handler: handler})
+	fs.nextCode += codes
+	logs.Logger.Infof("registered subprotocol %q for codes [%d,%d)", name, base, base+codes)
+	return base, nil
+}
+
+// dispatchMsg routes an inbound framed Msg to whichever protocol
+// registered its Code range.
+func (fs *FileServer) dispatchMsg(from string, msg p2p.Msg) error {
+	fs.protocolsLock.Lock()
+	var handler func(p2p.Peer, p2p.Msg) error
+	for _, p := range fs.protocols {
+		if msg.Code >= p.base && msg.Code < p.base+p.codes {
+			handler = p.handler
+			break
+		}
+	}
+	fs.protocolsLock.Unlock()
+
+	if handler == nil {
+		return p2p.NewPeerError(p2p.ErrInvalidMsgCode, "no subprotocol registered for msg code %d", msg.Code)
+	}
+
+	fs.peerLock.Lock()
+	peer, ok := fs.conns[from]
+	fs.peerLock.Unlock()
+	if !ok {
+		return fmt.Errorf("peer (%s) could not be found in the connection list", from)
+	}
+
+	return handler(peer, msg)
+}
+
+// handleBaseMsg serves the built-in filesystem/base subprotocol.
+// Ping/Pong and Handshake travel the framed Msg path; StoreFile/GetFile/
+// GetFileResponse keep their codes reserved here but still travel over
+// the legacy gob Message path during this transition window, so they
+// are not expected to reach this handler yet.
+func (fs *FileServer) handleBaseMsg(peer p2p.Peer, msg p2p.Msg) error {
+	switch msg.Code {
+	case p2p.PingMsg:
+		return peer.WriteMsg(p2p.PongMsg, struct{}{})
+	case p2p.PongMsg:
+		logs.Logger.Infof("[%s] pong from %s", fs.Transport.Addr(), peer.RemoteAddr())
+		return nil
+	case p2p.HandshakeMsg:
+		return fs.handleHandshake(peer, msg)
+	case p2p.DisconnectMsg:
+		return p2p.NewPeerError(p2p.ErrDisconnectRequested, "peer %s asked to disconnect", peer.RemoteAddr())
+	default:
+		return msg.Discard()
+	}
+}
+
+// handleHandshake validates an inbound HandshakeMessage and, once it
+// checks out, admits peer into fs.peers under its NodeID so it becomes
+// eligible for Store/Get/BroadCast. A peer that hasn't handshaked yet
+// stays reachable only via fs.conns, so it can't be selected to hold or
+// serve a key.
+func (fs *FileServer) handleHandshake(peer p2p.Peer, msg p2p.Msg) error {
+	var hs HandshakeMessage
+	if err := msg.Decode(&hs); err != nil {
+		return err
+	}
+	if hs.ProtocolVersion != ProtocolVersion {
+		return p2p.NewPeerError(p2p.ErrProtocolVersion, "peer %s speaks protocol version %d, want %d", peer.RemoteAddr(), hs.ProtocolVersion, ProtocolVersion)
 	}
+	if len(hs.NodeID) == 0 {
+		return p2p.NewPeerError(p2p.ErrInvalidMsgCode, "handshake from %s carried an empty NodeID", peer.RemoteAddr())
+	}
+
+	fs.peerLock.Lock()
+	fs.peers[string(hs.NodeID)] = peer
+	fs.peerListenAddrs[string(hs.NodeID)] = hs.ListenAddr
+	fs.rebuildSelectorLocked()
+	fs.peerLock.Unlock()
+
+	logs.Logger.Infof("[%s] peer %s identified as %s (protocol v%d), reachable at %s", fs.Transport.Addr(), peer.RemoteAddr(), hs.NodeID, hs.ProtocolVersion, hs.ListenAddr)
+	return nil
+}
+
+// Ping sends a liveness probe to peer over the framed Msg protocol.
+func (fs *FileServer) Ping(peer p2p.Peer) error {
+	return peer.WriteMsg(p2p.PingMsg, struct{}{})
+}
+
+// Disconnect tells peer we are ending the connection cleanly, so its
+// read loop classifies the resulting PeerError as ErrDisconnectRequested
+// instead of some opaque decode failure.
+func (fs *FileServer) Disconnect(peer p2p.Peer) error {
+	return peer.WriteMsg(p2p.DisconnectMsg, struct{}{})
 }
 
 func (fs *FileServer) Start() error {
@@ -69,60 +365,334 @@ func (fs *FileServer) Start() error {
 		logs.Logger.Errorf("Failed to Listen")
 		return err
 	}
+	if fs.NAT != nil {
+		go fs.runNAT() // Non Blocking
+	}
 	fs.bootStrapNetwork() // Non Blocking
 	fs.ReadLoop()         // Blocking
 	return nil
 }
 
+// natMapTimeout is how long a single AddMapping lease is requested for;
+// natRefreshInterval is how often it is renewed, well before it lapses.
+const (
+	natMapTimeout      = 20 * time.Minute
+	natRefreshInterval = 15 * time.Minute
+)
+
+// runNAT keeps ListenPort mapped on the gateway for as long as the
+// FileServer is running, refreshing the lease on a ticker since most
+// routers don't honor truly permanent mappings.
+func (fs *FileServer) runNAT() {
+	if fs.ListenPort == 0 {
+		logs.Logger.Warn("nat: configured but ListenPort is unset, skipping port mapping")
+		return
+	}
+
+	fs.refreshNATMapping()
+
+	ticker := time.NewTicker(natRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fs.refreshNATMapping()
+		case <-fs.stopped:
+			return
+		}
+	}
+}
+
+// refreshNATMapping maps ListenPort and, on success, records the
+// resulting external address so it can be announced to peers via
+// HandshakeMessage. Mapping failure is common (no UPnP/NAT-PMP router)
+// and deliberately logged at Warn rather than Error.
+func (fs *FileServer) refreshNATMapping() {
+	if err := fs.NAT.AddMapping("tcp", fs.ListenPort, fs.ListenPort, "distributed_file_system", natMapTimeout); err != nil {
+		logs.Logger.Warnf("nat: failed to map port %d via %s: %v", fs.ListenPort, fs.NAT, err)
+		return
+	}
+
+	ip, err := fs.NAT.ExternalIP()
+	if err != nil {
+		logs.Logger.Warnf("nat: failed to determine external IP via %s: %v", fs.NAT, err)
+		return
+	}
+
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(fs.ListenPort))
+	fs.peerLock.Lock()
+	fs.externalAddr = addr
+	fs.peerLock.Unlock()
+	logs.Logger.Infof("nat: mapped external address %s", addr)
+}
+
+// reportTiming reports how long the operation named name took, starting
+// at start. It is a no-op when no Reporter is configured.
+func (fs *FileServer) reportTiming(name string, start time.Time) {
+	if fs.Reporter != nil {
+		fs.Reporter.Timing(name, time.Since(start))
+	}
+}
+
+// reportCount reports a counter delta for name. It is a no-op when no
+// Reporter is configured.
+func (fs *FileServer) reportCount(name string, delta int64) {
+	if fs.Reporter != nil {
+		fs.Reporter.Count(name, delta)
+	}
+}
+
 func (fs *FileServer) Get(key string) (io.Reader, error) {
+	defer fs.reportTiming("fileserver.get", time.Now())
+
 	if fs.store.Has(fs.ID, key) {
 		logs.Logger.Infof("[%s] serving file (%s) from local disk\n", fs.Transport.Addr(), key)
 		_, r, err := fs.store.Read(fs.ID, key)
 		return r, err
 	}
 
+	size, err := fs.probeRemoteFileSize(key)
+	if err != nil {
+		return nil, err
+	}
+	return fs.GetRange(key, 0, size)
+}
+
+// GetRange serves the [offset, offset+length) byte range of key, from
+// local disk if present, otherwise from the network via a per-file block
+// cache so repeated or overlapping ranges only fetch missing blocks.
+func (fs *FileServer) GetRange(key string, offset, length int64) (io.Reader, error) {
+	if fs.store.Has(fs.ID, key) {
+		f, fileSize, err := fs.store.Open(fs.ID, key)
+		if err != nil {
+			return nil, err
+		}
+		if offset+length > fileSize {
+			length = fileSize - offset
+		}
+		return io.NewSectionReader(f, offset, length), nil
+	}
+
 	logs.Logger.Infof("[%s] dont have file (%s) locally, fetching from network...\n", fs.Transport.Addr(), key)
 
-	msg := Message{
-		Payload: MessageGetFile{
-			ID:  fs.ID,
-			Key: key,
-		},
+	cachedFile, err := fs.cachedFileFor(key)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := fs.BroadCast(&msg); err != nil {
+	data, err := cachedFile.ReadAt(offset, length)
+	if err != nil {
 		return nil, err
 	}
-	time.Sleep(time.Millisecond * 500)
 
-	// Any peer over the network will start streaming the data.
-	for _, peer := range fs.peers {
-		// First read the file size so we can limit the amount of bytes that we read
-		// from the connection, so it will not keep hanging.
-		var fileSize int64
-		binary.Read(peer, binary.LittleEndian, &fileSize)
-		_, err := fs.store.Write(fs.ID, key, io.LimitReader(peer, fileSize))
-		if err != nil {
+	// Only mirror a read that returned the entire file: a partial range
+	// (exactly what GetRange exists to serve) would otherwise be written
+	// to disk as if it were the whole file, and the next Has/Get would
+	// silently treat those few bytes as the complete, truncated file.
+	if offset == 0 && int64(len(data)) == cachedFile.FileSize() {
+		if _, err := fs.store.Write(fs.ID, key, bytes.NewReader(data)); err != nil {
 			logs.Logger.Errorf("Unable to Write the Data Fetched Over the Network.")
 		}
-		logs.Logger.Infof("[%s] received (%d) bytes over the network from (%s)", fs.Transport.Addr(), fileSize, peer.RemoteAddr())
-		peer.CloseStream()
 	}
 
-	_, r, err := fs.store.Read(fs.ID, key)
+	return bytes.NewReader(data), nil
+}
+
+// cachedFileFor returns the CachedFile backing key, probing its remote
+// size and creating one if this is the first time key has been requested.
+func (fs *FileServer) cachedFileFor(key string) (*cache.CachedFile, error) {
+	if cachedFile, ok := fs.fileCaches.Get(key); ok {
+		fs.reportCount("fileserver.cache_hit", 1)
+		return cachedFile, nil
+	}
+	fs.reportCount("fileserver.cache_miss", 1)
+
+	size, err := fs.probeRemoteFileSize(key)
 	if err != nil {
-		logs.Logger.Errorf("Cannot read from the store %s", key)
+		return nil, err
+	}
+
+	cachedFile, err := cache.NewCachedFile(size, fs.PerFileCacheBlocks, fs.fetchRemoteBlock(key))
+	if err != nil {
+		return nil, err
+	}
+	fs.fileCaches.Add(key, cachedFile)
+	return cachedFile, nil
+}
+
+// fetchRemoteBlock returns a cache.FetchFunc that pulls exactly one block
+// of key from the first of key's selected peers, in selector order, that
+// reports having it.
+func (fs *FileServer) fetchRemoteBlock(key string) cache.FetchFunc {
+	return func(offset, length int64) ([]byte, error) {
+		return fs.fetchRange(key, offset, length)
+	}
+}
+
+// probeRemoteFileSize asks the network for key's size without streaming
+// any of its bytes.
+func (fs *FileServer) probeRemoteFileSize(key string) (int64, error) {
+	size, _, err := fs.requestRange(key, 0, getFileLengthProbe)
+	return size, err
+}
+
+func (fs *FileServer) fetchRange(key string, offset, length int64) ([]byte, error) {
+	_, data, err := fs.requestRange(key, offset, length)
+	return data, err
+}
+
+// requestRange asks the ReplicationFactor peers responsible for key for
+// [offset, offset+length) and returns the file's total size plus whatever
+// bytes the first peer that reports Found streamed back. Peers that reply
+// Found: false are skipped without waiting out a fixed sleep.
+func (fs *FileServer) requestRange(key string, offset, length int64) (int64, []byte, error) {
+	targets := fs.selectPeers(key)
+	if len(targets) == 0 {
+		return 0, nil, fmt.Errorf("no peers available to serve key (%s)", key)
+	}
+
+	waiters := make(map[string]chan getFileResult, len(targets))
+	for _, peer := range targets {
+		id := peer.RemoteAddr().String()
+		waiters[id] = fs.registerGetWaiter(key, id)
+	}
+	defer func() {
+		for id := range waiters {
+			fs.clearGetWaiter(key, id)
+		}
+	}()
+
+	msg := Message{
+		Payload: MessageGetFile{
+			ID:     fs.ID,
+			Key:    key,
+			Offset: offset,
+			Length: length,
+		},
+	}
+	if err := fs.sendTo(targets, &msg); err != nil {
+		return 0, nil, err
+	}
+
+	// Every target was sent the same request, so every one that reports
+	// Found will have streamed a response back, unless it had nothing to
+	// send (a zero-length probe or a range that clipped to nothing -
+	// handleMessageGetFile skips the stream marker for those) - regardless
+	// of whether it ends up being the peer whose bytes we keep, each real
+	// stream has to be drained and closed here, or its write blocks
+	// forever on the other end and that connection's read loop never
+	// resumes - so this can't stop at the first match the way it used to;
+	// it has to walk every target.
+	var (
+		primarySize int64
+		primaryData []byte
+		primaryErr  error
+		havePrimary bool
+	)
+	for _, peer := range targets {
+		res, ok := <-waiters[peer.RemoteAddr().String()]
+		if !ok || !res.found {
+			continue
+		}
+
+		wantLen := length
+		if length == getFileLengthProbe {
+			wantLen = 0
+		} else if offset+wantLen > res.size {
+			wantLen = res.size - offset
+		}
+
+		data := make([]byte, wantLen)
+		if wantLen > 0 {
+			if _, err := io.ReadFull(peer, data); err != nil {
+				peer.CloseStream()
+				if !havePrimary && primaryErr == nil {
+					primaryErr = fmt.Errorf("reading (%d) bytes from (%s): %w", wantLen, peer.RemoteAddr(), err)
+				}
+				continue
+			}
+			logs.Logger.Infof("[%s] received (%d) bytes over the network from (%s)", fs.Transport.Addr(), len(data), peer.RemoteAddr())
+			peer.CloseStream()
+		}
+
+		if !havePrimary {
+			primarySize, primaryData, havePrimary = res.size, data, true
+		}
+	}
+
+	if havePrimary {
+		return primarySize, primaryData, nil
+	}
+	if primaryErr != nil {
+		return 0, nil, primaryErr
+	}
+	return 0, nil, fmt.Errorf("no peer responded for key (%s)", key)
+}
+
+// selectPeers returns the connected peers among the ReplicationFactor
+// closest, per fs.selector, to key.
+func (fs *FileServer) selectPeers(key string) []p2p.Peer {
+	fs.peerLock.Lock()
+	defer fs.peerLock.Unlock()
+
+	ids := fs.selector.Pick(key, fs.ReplicationFactor)
+	peers := make([]p2p.Peer, 0, len(ids))
+	for _, id := range ids {
+		if peer, ok := fs.peers[string(id)]; ok {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+func (fs *FileServer) registerGetWaiter(key, peerID string) chan getFileResult {
+	ch := make(chan getFileResult, 1)
+	fs.getWaitersLock.Lock()
+	fs.getWaiters[getWaitKey(key, peerID)] = ch
+	fs.getWaitersLock.Unlock()
+
+	go func() {
+		time.Sleep(getFileResponseTimeout)
+		fs.getWaitersLock.Lock()
+		if fs.getWaiters[getWaitKey(key, peerID)] == ch {
+			close(ch)
+		}
+		fs.getWaitersLock.Unlock()
+	}()
+
+	return ch
+}
+
+func (fs *FileServer) clearGetWaiter(key, peerID string) {
+	fs.getWaitersLock.Lock()
+	delete(fs.getWaiters, getWaitKey(key, peerID))
+	fs.getWaitersLock.Unlock()
+}
+
+func (fs *FileServer) deliverGetResponse(peerID string, msg MessageGetFileResponse) {
+	fs.getWaitersLock.Lock()
+	ch, ok := fs.getWaiters[getWaitKey(msg.Key, peerID)]
+	fs.getWaitersLock.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case ch <- getFileResult{found: msg.Found, size: msg.Size}:
+	default:
 	}
-	return r, err
 }
 
 func (fs *FileServer) Store(key string, r io.Reader) error {
+	defer fs.reportTiming("fileserver.store", time.Now())
+
 	var (
 		fileBuffer = new(bytes.Buffer)
 		tee        = io.TeeReader(r, fileBuffer)
 	)
 	// 1. SAVE THE FILE TO THIS DISK and get the size of the file (important for EOF on the network)
+	diskWriteStart := time.Now()
 	size, err := fs.store.Write(fs.ID, key, tee)
+	fs.reportTiming("fileserver.store.disk_write", diskWriteStart)
 	if err != nil {
 		return err
 	}
@@ -133,16 +703,20 @@ func (fs *FileServer) Store(key string, r io.Reader) error {
 			Size: size, // Specify the data size. (important)
 		},
 	}
-	// 2. BROADCAST THE FILE TO ALL KNONW PEERS IN THE NETWORK.
-	// Broadcast the key over the network
-	if err := fs.BroadCast(&msg); err != nil {
+	// 2. STREAM THE FILE TO THE REPLICATIONFACTOR PEERS RESPONSIBLE FOR key,
+	// instead of every peer in the network.
+	broadcastStart := time.Now()
+	targets := fs.selectPeers(key)
+	if err := fs.sendTo(targets, &msg); err != nil {
 		return err
 	}
+	fs.reportTiming("fileserver.store.broadcast", broadcastStart)
 
 	time.Sleep(time.Millisecond * 1000)
 
+	streamStart := time.Now()
 	peers := []io.Writer{}
-	for _, peer := range fs.peers {
+	for _, peer := range targets {
 		peers = append(peers, peer)
 	}
 	mw := io.MultiWriter(peers...)
@@ -151,22 +725,43 @@ func (fs *FileServer) Store(key string, r io.Reader) error {
 		logs.Logger.Errorf("Failed to stream data.")
 		return err
 	}
+	fs.reportTiming("fileserver.store.stream_copy", streamStart)
 	logs.Logger.Infof("[%s] received and written (%d) bytes to disk\n", fs.Transport.Addr(), size)
 	return nil
 }
 
-// Only Broadcasting the message.
+// BroadCast sends msg to every connected peer. Store/Get no longer use
+// this directly now that they target the ReplicationFactor peers picked by
+// fs.selector, but it remains the right tool for network-wide control
+// messages (e.g. a future discovery announcement).
 func (fs *FileServer) BroadCast(msg *Message) error {
+	fs.peerLock.Lock()
+	peers := make([]p2p.Peer, 0, len(fs.peers))
+	for _, peer := range fs.peers {
+		peers = append(peers, peer)
+	}
+	fs.peerLock.Unlock()
+
+	return fs.sendTo(peers, msg)
+}
+
+// sendTo gob-encodes msg once and streams it, length-prefixed, to exactly
+// the given peers. The length prefix lets the receiver read exactly one
+// message with io.ReadFull instead of trusting a single Read call to
+// return the whole thing, which over real TCP is only true by luck.
+func (fs *FileServer) sendTo(peers []p2p.Peer, msg *Message) error {
 	buf := new(bytes.Buffer)
 	if err := gob.NewEncoder(buf).Encode(msg); err != nil {
 		return err
 	}
-	for _, peer := range fs.peers {
-		if err := peer.Send([]byte{p2p.IncomingMessage}); err != nil { // First send the incoming message after encoding.
-			logs.Logger.Error(err)
-			return err
-		}
-		if err := peer.Send(buf.Bytes()); err != nil {
+
+	frame := make([]byte, 1+4, 1+4+buf.Len())
+	frame[0] = p2p.IncomingMessage
+	binary.BigEndian.PutUint32(frame[1:5], uint32(buf.Len()))
+	frame = append(frame, buf.Bytes()...)
+
+	for _, peer := range peers {
+		if err := peer.Send(frame); err != nil {
 			logs.Logger.Error(err)
 			return err
 		}
@@ -176,6 +771,12 @@ func (fs *FileServer) BroadCast(msg *Message) error {
 }
 
 func (fs *FileServer) Stop() error {
+	fs.stopOnce.Do(func() { close(fs.stopped) })
+	if fs.NAT != nil && fs.ListenPort != 0 {
+		if err := fs.NAT.DeleteMapping("tcp", fs.ListenPort, fs.ListenPort); err != nil {
+			logs.Logger.Warnf("nat: failed to delete mapping for port %d: %v", fs.ListenPort, err)
+		}
+	}
 	fs.quitch <- struct{}{}
 	return nil
 }
@@ -183,15 +784,90 @@ func (fs *FileServer) Stop() error {
 // Make sure that only a single go routine can change the
 // peers map at a time
 // map read is optimized for concurrent read but not map write.
+//
+// OnPeer only registers p in fs.conns and sends our handshake; p is not
+// eligible for Store/Get/BroadCast until its own handshake comes back
+// and handleHandshake admits it into fs.peers.
 func (s *FileServer) OnPeer(p p2p.Peer) error {
+	addr := p.RemoteAddr().String()
+
 	s.peerLock.Lock()
-	defer s.peerLock.Unlock()
+	wrapped := metrics.WrapPeer(p, s.Reporter)
+	s.conns[addr] = wrapped
+	externalAddr := s.externalAddr
+	s.peerLock.Unlock()
+
+	logs.Logger.Infof("connected with remote %s", addr)
+
+	hs := HandshakeMessage{
+		NodeID:          s.NodeID,
+		ProtocolVersion: ProtocolVersion,
+		Caps:            s.Caps,
+		ListenAddr:      externalAddr,
+	}
+
+	// Sent in a goroutine rather than inline: OnPeer must return before
+	// the transport starts this connection's read loop (see
+	// PipeTransport.handlePeer/TCPTransport.handleConn), so a synchronous
+	// write here would block forever over an unbuffered transport like
+	// p2p.MsgPipe, where both ends of a connection run exactly this same
+	// code and neither's read loop can start until its own OnPeer
+	// returns.
+	go func() {
+		if err := wrapped.WriteMsg(p2p.HandshakeMsg, hs); err != nil {
+			logs.Logger.Warnf("failed to send handshake to %s: %v", addr, err)
+		}
+	}()
 
-	s.peers[p.RemoteAddr().String()] = p
-	logs.Logger.Infof("connected with remote %s", p.RemoteAddr().String())
+	go s.awaitHandshake(addr)
 	return nil
 }
 
+// awaitHandshake drops addr's connection if it hasn't completed a
+// handshake within handshakeTimeout, so a peer that connects but never
+// identifies itself doesn't sit around forever as a conns entry.
+func (fs *FileServer) awaitHandshake(addr string) {
+	time.Sleep(handshakeTimeout)
+
+	fs.peerLock.Lock()
+	conn, connected := fs.conns[addr]
+	handshaked := false
+	for _, p := range fs.peers {
+		if p == conn {
+			handshaked = true
+			break
+		}
+	}
+	fs.peerLock.Unlock()
+
+	if connected && !handshaked {
+		fs.handleDispatchError(addr, p2p.NewPeerError(p2p.ErrTimeout, "peer %s did not complete a handshake within %s", addr, handshakeTimeout))
+	}
+}
+
+// rebuildSelectorLocked refreshes the selector's view of the network
+// from the handshaked peers in fs.peers (keyed by NodeID). Callers must
+// hold peerLock.
+func (s *FileServer) rebuildSelectorLocked() {
+	ids := make([]selector.PeerID, 0, len(s.peers))
+	for id := range s.peers {
+		ids = append(ids, selector.PeerID(id))
+	}
+	s.selector.SetPeers(ids)
+}
+
+// ListenAddrFor returns the NAT-mapped ip:port the peer identified by
+// nodeID announced in its handshake, so a caller wanting to reconnect
+// dials the mapped endpoint rather than the address the original
+// connection happened to arrive from. The second return is false if
+// nodeID hasn't completed a handshake (or never announced an address).
+func (fs *FileServer) ListenAddrFor(nodeID p2p.NodeID) (string, bool) {
+	fs.peerLock.Lock()
+	defer fs.peerLock.Unlock()
+	addr, ok := fs.peerListenAddrs[string(nodeID)]
+	return addr, ok && addr != ""
+}
+
 func (fs *FileServer) ReadLoop() {
 	// Keeps on looping for ever unitl quit. Blockin in nature.
 	// Unless select it will again keeps on listenitng even if a channel has been hadled once.
@@ -202,7 +878,18 @@ func (fs *FileServer) ReadLoop() {
 	for {
 		select {
 		case rpc := <-fs.Transport.Consume():
-			var m Message // This is what recived over the wire.
+			if rpc.IsMsg {
+				msg := p2p.Msg{Code: rpc.Code, Size: uint32(len(rpc.Payload)), Payload: bytes.NewReader(rpc.Payload)}
+				if err := fs.dispatchMsg(rpc.From, msg); err != nil {
+					fs.handleDispatchError(rpc.From, err)
+				}
+				continue
+			}
+
+			// Legacy path: a gob-encoded Message, kept as a compatibility
+			// shim while StoreFile/GetFile/GetFileResponse are migrated
+			// onto the framed Msg protocol above.
+			var m Message
 			if err := gob.NewDecoder(bytes.NewReader(rpc.Payload)).Decode(&m); err != nil {
 				logs.Logger.Errorf("Decoding Error %+v", err)
 
@@ -218,6 +905,41 @@ func (fs *FileServer) ReadLoop() {
 	}
 }
 
+// handleDispatchError classifies err and, for a *p2p.PeerError, logs the
+// peer's specific failure mode and drops the connection, since a peer
+// that doesn't speak our protocol shouldn't linger around to be
+// selected for replication. Any other error is treated as transient and
+// just logged, as before.
+func (fs *FileServer) handleDispatchError(from string, err error) {
+	var perr *p2p.PeerError
+	if !errors.As(err, &perr) {
+		logs.Logger.Error(err)
+		return
+	}
+	logs.Logger.Warnf("peer %s: %v", from, perr)
+	fs.dropPeer(from)
+}
+
+// dropPeer closes and forgets the connection at from, along with
+// whatever NodeID it had completed a handshake under, if any.
+func (fs *FileServer) dropPeer(from string) {
+	fs.peerLock.Lock()
+	conn, ok := fs.conns[from]
+	delete(fs.conns, from)
+	for id, p := range fs.peers {
+		if p == conn {
+			delete(fs.peers, id)
+			delete(fs.peerListenAddrs, id)
+		}
+	}
+	fs.rebuildSelectorLocked()
+	fs.peerLock.Unlock()
+
+	if ok {
+		conn.Close()
+	}
+}
+
 func (fs *FileServer) handleMessage(from string, msg *Message) error {
 	switch v := msg.Payload.(type) {
 	case MessageStoreFile:
@@ -225,14 +947,16 @@ func (fs *FileServer) handleMessage(from string, msg *Message) error {
 		return fs.handleMessageStoreFile(from, &v)
 	case MessageGetFile:
 		return fs.handleMessageGetFile(from, v)
+	case MessageGetFileResponse:
+		fs.deliverGetResponse(from, v)
 	}
 	return nil
 }
 
 func (fs *FileServer) handleMessageStoreFile(from string, msg *MessageStoreFile) error {
-	peer, ok := fs.peers[from]
+	peer, ok := fs.conns[from]
 	if !ok {
-		return fmt.Errorf("peer (%s) could not be found in the peer list", from)
+		return fmt.Errorf("peer (%s) could not be found in the connection list", from)
 	}
 	// A limit reader is necassary as over the network
 	// when reading from the connection directly it will not send the EOF.
@@ -248,32 +972,57 @@ func (fs *FileServer) handleMessageStoreFile(from string, msg *MessageStoreFile)
 }
 
 func (s *FileServer) handleMessageGetFile(from string, msg MessageGetFile) error {
-	if !s.store.Has(msg.ID, msg.Key) {
-		return fmt.Errorf("[%s] need to serve file (%s) but it does not exist on disk", s.Transport.Addr(), msg.Key)
+	peer, ok := s.conns[from]
+	if !ok {
+		return fmt.Errorf("peer %s not in map", from)
 	}
 
-	fmt.Printf("[%s] serving file (%s) over the network\n", s.Transport.Addr(), msg.Key)
+	if !s.store.Has(msg.ID, msg.Key) {
+		resp := Message{Payload: MessageGetFileResponse{Key: msg.Key, Found: false}}
+		return s.sendTo([]p2p.Peer{peer}, &resp)
+	}
 
-	fileSize, r, err := s.store.Read(msg.ID, msg.Key)
+	f, fileSize, err := s.store.Open(msg.ID, msg.Key)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	if rc, ok := r.(io.ReadCloser); ok {
-		fmt.Println("closing readCloser")
-		defer rc.Close()
+	length := msg.Length
+	switch {
+	case length == getFileLengthProbe:
+		length = 0
+	case length <= 0:
+		length = fileSize - msg.Offset
+	}
+	if msg.Offset+length > fileSize {
+		length = fileSize - msg.Offset
 	}
 
-	peer, ok := s.peers[from]
-	if !ok {
-		return fmt.Errorf("peer %s not in map", from)
+	resp := Message{Payload: MessageGetFileResponse{Key: msg.Key, Found: true, Size: fileSize}}
+	if err := s.sendTo([]p2p.Peer{peer}, &resp); err != nil {
+		return err
+	}
+
+	fmt.Printf("[%s] serving file (%s) range [%d,%d) over the network\n", s.Transport.Addr(), msg.Key, msg.Offset, msg.Offset+length)
+
+	// A zero-length answer (a size probe, or a range that clipped to
+	// nothing) has no bytes to frame, so skip the stream marker entirely
+	// instead of pausing the peer's read loop for nothing: the requester
+	// has no data-bearing read to pair with it, and racing a CloseStream
+	// against this same peer's own wg.Add for an empty stream is how a
+	// multi-target request (requestRange's other peers) trips the read
+	// loop's "negative WaitGroup" guard.
+	if length == 0 {
+		return nil
 	}
 
-	// First send the "incomingStream" byte to the peer and then we can send
-	// the file size as an int64.
+	// First send the "incomingStream" byte to the peer, then exactly
+	// `length` bytes starting at msg.Offset (the requester already knows
+	// the file's total size from the MessageGetFileResponse above).
 	peer.Send([]byte{p2p.IncomingStream})
-	binary.Write(peer, binary.LittleEndian, fileSize)
-	n, err := io.Copy(peer, r)
+	sr := io.NewSectionReader(f, msg.Offset, length)
+	n, err := io.Copy(peer, sr)
 	if err != nil {
 		return err
 	}
@@ -302,4 +1051,5 @@ func (fs *FileServer) bootStrapNetwork() error {
 func init() {
 	gob.Register(MessageStoreFile{})
 	gob.Register(MessageGetFile{})
+	gob.Register(MessageGetFileResponse{})
 }
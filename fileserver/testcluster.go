@@ -0,0 +1,58 @@
+package fileserver
+
+import (
+	"fmt"
+
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+)
+
+// NewTestCluster wires n FileServers together over in-memory p2p.MsgPipes
+// (via p2p.PipeTransport) instead of real TCP connections, so Store/Get,
+// replication, and failure cases (peer close mid-stream, partial writes,
+// duplicate keys) can be exercised without ports or sockets. Store and Get
+// still run their usual fixed waits over the pipes (Store's pause before
+// streaming, Get's per-peer response timeout), and a new cluster needs a
+// moment for its handshakes to land before it is usable.
+//
+// Callers are responsible for calling Stop on each returned FileServer
+// once done with it.
+func NewTestCluster(n int) []*FileServer {
+	network := p2p.NewPipeNetwork()
+
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("node-%d", i)
+	}
+
+	servers := make([]*FileServer, n)
+	for i, addr := range addrs {
+		// Each node only dials the nodes that came before it, so every
+		// pair ends up joined by exactly one MsgPipe. Dialing both
+		// directions would give a pair two independent connections, and
+		// since fs.conns is keyed by remote address (and fs.peers by
+		// NodeID), whichever connection registers last would silently win
+		// the map entry while a stream
+		// already in flight on the other connection is never read.
+		peers := append([]string(nil), addrs[:i]...)
+
+		// ID is deliberately left unset: it namespaces a file's owner on
+		// disk, and must match across every replica of a cluster, not
+		// identify the node (see NewFileServer's "1234" default).
+		transport := p2p.NewPipeTransport(network, addr)
+		fs := NewFileServer(FileServerOpts{
+			StorageRoot:    "testcluster_" + addr,
+			Transport:      transport,
+			BootStrapNodes: peers,
+		})
+		transport.OnPeer = fs.OnPeer
+		servers[i] = fs
+	}
+
+	for _, fs := range servers {
+		_ = fs.Transport.ListenAndAccept() // PipeTransport.ListenAndAccept never errors
+		fs.bootStrapNetwork()
+		go fs.ReadLoop()
+	}
+
+	return servers
+}
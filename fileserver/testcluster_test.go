@@ -0,0 +1,165 @@
+package fileserver
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestClusterT is NewTestCluster plus the handshake settle and cleanup
+// every test below needs: a cluster is unusable until each pair's
+// OnPeer-spawned handshake has landed, and every node leaves a
+// "testcluster_<addr>" directory behind on disk once it has stored
+// anything.
+func newTestClusterT(t *testing.T, n int) []*FileServer {
+	t.Helper()
+
+	servers := NewTestCluster(n)
+	t.Cleanup(func() {
+		for _, fs := range servers {
+			fs.Stop()
+			os.RemoveAll(fs.StorageRoot)
+		}
+	})
+
+	time.Sleep(100 * time.Millisecond)
+	return servers
+}
+
+func TestTestClusterStoreGet(t *testing.T) {
+	servers := newTestClusterT(t, 3)
+
+	key, want := "hello.txt", "hello world"
+	if err := servers[0].Store(key, bytes.NewReader([]byte(want))); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	for i, fs := range servers {
+		r, err := fs.Get(key)
+		if err != nil {
+			t.Fatalf("servers[%d].Get(%q): %v", i, key, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("servers[%d]: read response: %v", i, err)
+		}
+		if string(got) != want {
+			t.Fatalf("servers[%d].Get(%q) = %q, want %q", i, key, got, want)
+		}
+	}
+}
+
+func TestTestClusterGetRangePartial(t *testing.T) {
+	// More nodes than the default ReplicationFactor, so at least one node
+	// is not a direct Store replication target and must actually serve
+	// GetRange off the network/cache path instead of local disk.
+	servers := newTestClusterT(t, 5)
+
+	key, content := "range.txt", "0123456789"
+	if err := servers[0].Store(key, bytes.NewReader([]byte(content))); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	// 5 nodes is more than defaultReplicationFactor's 3, so Store only
+	// replicates to the origin plus its 3 closest peers per the selector -
+	// one node is guaranteed to lack the file locally and must actually
+	// serve GetRange off the network/cache path instead of disk. Which
+	// specific node that is varies run to run (NodeIDs are randomized), so
+	// find it rather than assuming an index.
+	var reader *FileServer
+	for _, fs := range servers {
+		if !fs.store.Has(fs.ID, key) {
+			reader = fs
+			break
+		}
+	}
+	if reader == nil {
+		t.Fatalf("every node in the cluster already has %q locally; can't exercise a network GetRange", key)
+	}
+
+	r, err := reader.GetRange(key, 2, 4)
+	if err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if want := content[2:6]; string(got) != want {
+		t.Fatalf("GetRange(2, 4) = %q, want %q", got, want)
+	}
+
+	// A partial range must not be mirrored to disk as if it were the
+	// whole file: the next full Get has to still pull the remainder
+	// over the network rather than silently serving the 4 bytes above.
+	if reader.store.Has(reader.ID, key) {
+		t.Fatalf("partial GetRange mirrored %q to disk as the complete file", key)
+	}
+
+	full, err := reader.Get(key)
+	if err != nil {
+		t.Fatalf("Get after partial GetRange: %v", err)
+	}
+	gotFull, err := io.ReadAll(full)
+	if err != nil {
+		t.Fatalf("read full response: %v", err)
+	}
+	if string(gotFull) != content {
+		t.Fatalf("Get(%q) = %q, want %q", key, gotFull, content)
+	}
+}
+
+func TestTestClusterDuplicateKey(t *testing.T) {
+	servers := newTestClusterT(t, 2)
+
+	key := "dup.txt"
+	for i, want := range []string{"first version", "second version"} {
+		if err := servers[0].Store(key, bytes.NewReader([]byte(want))); err != nil {
+			t.Fatalf("Store #%d: %v", i, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+
+		r, err := servers[1].Get(key)
+		if err != nil {
+			t.Fatalf("Get #%d: %v", i, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read response #%d: %v", i, err)
+		}
+		if string(got) != want {
+			t.Fatalf("Get(%q) after Store #%d = %q, want %q", key, i, got, want)
+		}
+	}
+}
+
+func TestTestClusterNPeersReplicate(t *testing.T) {
+	const n = 4
+	servers := newTestClusterT(t, n)
+
+	key, want := "fanout.txt", "replicated across the cluster"
+	if err := servers[0].Store(key, bytes.NewReader([]byte(want))); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	for i := 1; i < n; i++ {
+		r, err := servers[i].Get(key)
+		if err != nil {
+			t.Fatalf("servers[%d].Get: %v", i, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("servers[%d]: read response: %v", i, err)
+		}
+		if string(got) != want {
+			t.Fatalf("servers[%d].Get(%q) = %q, want %q", i, key, got, want)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+// Package logs provides the single sugared logger instance used across the
+// distributed file system. It wraps zap so call sites can use printf-style
+// verbs (Infof, Errorf, ...) without every package configuring its own
+// logger.
+package logs
+
+import "go.uber.org/zap"
+
+// Logger is the process-wide sugared logger. It is initialized in init so
+// it is always safe to use from package level code (e.g. server startup).
+var Logger *zap.SugaredLogger
+
+func init() {
+	l, err := zap.NewProduction()
+	if err != nil {
+		panic(err)
+	}
+	Logger = l.Sugar()
+}
@@ -0,0 +1,62 @@
+// Package metrics defines the Reporter interface FileServer uses for
+// observability (counters, gauges, timings) and a statsd implementation
+// of it, so operators can wire up metrics without touching core code
+// paths. A nil Reporter disables reporting entirely.
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Reporter emits application metrics to wherever an operator wants them
+// to go.
+type Reporter interface {
+	Count(name string, delta int64)
+	Gauge(name string, value float64)
+	Timing(name string, d time.Duration)
+}
+
+// StatsdReporter reports metrics to a statsd server over UDP using the
+// standard "name:value|type" line protocol.
+type StatsdReporter struct {
+	conn *net.UDPConn
+}
+
+// NewStatsdReporter dials addr (host:port) over UDP. As with any statsd
+// client, this "connection" is just a local socket bound to addr: Dial
+// only fails if addr cannot be resolved, not if anything is listening.
+func NewStatsdReporter(addr string) (*StatsdReporter, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsdReporter{conn: conn}, nil
+}
+
+func (s *StatsdReporter) Count(name string, delta int64) {
+	s.send(fmt.Sprintf("%s:%d|c", name, delta))
+}
+
+func (s *StatsdReporter) Gauge(name string, value float64) {
+	s.send(fmt.Sprintf("%s:%f|g", name, value))
+}
+
+func (s *StatsdReporter) Timing(name string, d time.Duration) {
+	s.send(fmt.Sprintf("%s:%d|ms", name, d.Milliseconds()))
+}
+
+// send is fire-and-forget: a dropped UDP packet must never block or fail
+// whatever operation is being measured.
+func (s *StatsdReporter) send(line string) {
+	s.conn.Write([]byte(line))
+}
+
+func (s *StatsdReporter) Close() error {
+	return s.conn.Close()
+}
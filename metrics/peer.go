@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/ranjankuldeep/distributed_file_system/p2p"
+)
+
+// instrumentedPeer wraps a p2p.Peer the way an external StatsdConn wraps
+// a net.Conn: every byte read/written through Read/Write/Send and every
+// framed Msg's code passes through to r, with no change to the peer's own
+// behavior. The bytes of ReadMsg/WriteMsg frames are not counted.
+type instrumentedPeer struct {
+	p2p.Peer
+	r Reporter
+}
+
+// WrapPeer decorates p so Reads/Writes/Sends and ReadMsg/WriteMsg calls
+// are reported through r. Send and WriteMsg need their own overrides
+// rather than falling out of Read/Write: every TCPPeer/PipePeer
+// implements them by writing straight to its underlying net.Conn, not by
+// calling back through its own Write method, so without this they would
+// reach the embedded p2p.Peer directly and never hit our counters. A nil
+// Reporter makes WrapPeer a no-op, so callers can wrap unconditionally
+// regardless of whether metrics are configured.
+func WrapPeer(p p2p.Peer, r Reporter) p2p.Peer {
+	if r == nil {
+		return p
+	}
+	return &instrumentedPeer{Peer: p, r: r}
+}
+
+func (p *instrumentedPeer) Read(b []byte) (int, error) {
+	n, err := p.Peer.Read(b)
+	p.r.Count("peer.bytes_read", int64(n))
+	return n, err
+}
+
+func (p *instrumentedPeer) Write(b []byte) (int, error) {
+	n, err := p.Peer.Write(b)
+	p.r.Count("peer.bytes_written", int64(n))
+	return n, err
+}
+
+func (p *instrumentedPeer) ReadMsg() (p2p.Msg, error) {
+	msg, err := p.Peer.ReadMsg()
+	if err == nil {
+		p.r.Count(fmt.Sprintf("peer.msg_code.%d", msg.Code), 1)
+	}
+	return msg, err
+}
+
+func (p *instrumentedPeer) Send(b []byte) error {
+	err := p.Peer.Send(b)
+	if err == nil {
+		p.r.Count("peer.bytes_written", int64(len(b)))
+	}
+	return err
+}
+
+func (p *instrumentedPeer) WriteMsg(code uint64, val any) error {
+	err := p.Peer.WriteMsg(code, val)
+	if err == nil {
+		p.r.Count(fmt.Sprintf("peer.msg_code.%d", code), 1)
+	}
+	return err
+}
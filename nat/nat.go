@@ -0,0 +1,130 @@
+// Package nat implements external port mapping for nodes behind NAT, so
+// a FileServer can announce an address peers can actually dial instead
+// of the one a gateway happens to present as the connection's observed
+// source.
+package nat
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interface abstracts a port-mapping protocol (UPnP IGD, NAT-PMP)
+// discovered on the local network.
+type Interface interface {
+	// AddMapping forwards extPort on the gateway to intPort on this host
+	// for protocol ("tcp" or "udp"), labeled desc, expiring after
+	// duration. Routers that do not honor permanent mappings need this
+	// refreshed well before it lapses.
+	AddMapping(protocol string, extPort, intPort int, desc string, duration time.Duration) error
+	// DeleteMapping removes a mapping previously added with AddMapping.
+	DeleteMapping(protocol string, extPort, intPort int) error
+	// ExternalIP returns the gateway's external (public) IP address.
+	ExternalIP() (net.IP, error)
+	// String names the mapping mechanism, for logging.
+	String() string
+}
+
+// Parse parses a NAT option string ("upnp", "pmp", "extip:1.2.3.4", ""
+// or "none") the way a command-line flag would. "" and "none" mean no
+// NAT traversal, returned as a nil Interface.
+func Parse(spec string) (Interface, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	switch strings.ToLower(parts[0]) {
+	case "", "none":
+		return nil, nil
+	case "upnp":
+		return UPnP(), nil
+	case "pmp", "natpmp", "nat-pmp":
+		return PMP(), nil
+	case "extip":
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("nat: missing IP address in extip:<IP>")
+		}
+		ip := net.ParseIP(parts[1])
+		if ip == nil {
+			return nil, fmt.Errorf("nat: invalid IP in extip:<IP>")
+		}
+		return ExtIP(ip), nil
+	default:
+		return nil, fmt.Errorf("nat: unknown mechanism %q", parts[0])
+	}
+}
+
+// ExtIP implements Interface for a statically configured external IP: no
+// mapping is actually performed, on the assumption the operator has
+// already forwarded the port by hand.
+type ExtIP net.IP
+
+func (n ExtIP) ExternalIP() (net.IP, error)                              { return net.IP(n), nil }
+func (n ExtIP) String() string                                           { return fmt.Sprintf("extip:%v", net.IP(n)) }
+func (n ExtIP) AddMapping(string, int, int, string, time.Duration) error { return nil }
+func (n ExtIP) DeleteMapping(string, int, int) error                     { return nil }
+
+// autodisc defers discovery of a gateway to the first call made against
+// it, so UPnP()/PMP() can return an Interface synchronously even when
+// the local network has no such gateway (the error simply surfaces on
+// first use instead of at construction).
+type autodisc struct {
+	what     string
+	discover func() (Interface, error)
+
+	once  sync.Once
+	found Interface
+	err   error
+}
+
+func (ad *autodisc) resolve() (Interface, error) {
+	ad.once.Do(func() {
+		ad.found, ad.err = ad.discover()
+		if ad.err != nil {
+			ad.err = fmt.Errorf("%s: %w", ad.what, ad.err)
+		}
+	})
+	return ad.found, ad.err
+}
+
+func (ad *autodisc) AddMapping(protocol string, extPort, intPort int, desc string, duration time.Duration) error {
+	found, err := ad.resolve()
+	if err != nil {
+		return err
+	}
+	return found.AddMapping(protocol, extPort, intPort, desc, duration)
+}
+
+func (ad *autodisc) DeleteMapping(protocol string, extPort, intPort int) error {
+	found, err := ad.resolve()
+	if err != nil {
+		return err
+	}
+	return found.DeleteMapping(protocol, extPort, intPort)
+}
+
+func (ad *autodisc) ExternalIP() (net.IP, error) {
+	found, err := ad.resolve()
+	if err != nil {
+		return nil, err
+	}
+	return found.ExternalIP()
+}
+
+func (ad *autodisc) String() string { return ad.what }
+
+// discoveryTimeout bounds how long UPnP/NAT-PMP discovery waits for a
+// gateway to answer before giving up.
+const discoveryTimeout = 3 * time.Second
+
+// UPnP returns an Interface that discovers a UPnP Internet Gateway
+// Device via SSDP on first use.
+func UPnP() Interface {
+	return &autodisc{what: "UPnP", discover: func() (Interface, error) { return discoverUPnP(discoveryTimeout) }}
+}
+
+// PMP returns an Interface that speaks NAT-PMP (RFC 6886) to the default
+// gateway on first use.
+func PMP() Interface {
+	return &autodisc{what: "NAT-PMP", discover: func() (Interface, error) { return discoverPMP(discoveryTimeout) }}
+}
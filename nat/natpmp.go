@@ -0,0 +1,144 @@
+package nat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// pmp implements Interface using NAT-PMP (RFC 6886) against the default
+// gateway.
+type pmp struct {
+	gw net.IP
+}
+
+const pmpPort = 5351
+
+// discoverPMP guesses the default gateway from the local route to the
+// internet and confirms it speaks NAT-PMP by asking for its external IP.
+func discoverPMP(timeout time.Duration) (*pmp, error) {
+	gw, err := defaultGateway()
+	if err != nil {
+		return nil, err
+	}
+	p := &pmp{gw: gw}
+	if _, err := p.externalIP(timeout); err != nil {
+		return nil, fmt.Errorf("no NAT-PMP gateway at %v: %w", gw, err)
+	}
+	return p, nil
+}
+
+// defaultGateway assumes the common home-router convention of the
+// gateway sitting at x.x.x.1 on the host's outbound interface.
+func defaultGateway() (net.IP, error) {
+	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	local := conn.LocalAddr().(*net.UDPAddr).IP.To4()
+	if local == nil {
+		return nil, fmt.Errorf("no IPv4 route to determine a default gateway")
+	}
+	gw := make(net.IP, 4)
+	copy(gw, local)
+	gw[3] = 1
+	return gw, nil
+}
+
+func (p *pmp) request(req []byte, respLen int, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", fmt.Sprintf("%s:%d", p.gw, pmpPort), timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	buf := make([]byte, respLen)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < respLen {
+		return nil, fmt.Errorf("short NAT-PMP response (%d bytes)", n)
+	}
+	return buf[:n], nil
+}
+
+func (p *pmp) externalIP(timeout time.Duration) (net.IP, error) {
+	resp, err := p.request([]byte{0, 0}, 12, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if resp[1] != 128 {
+		return nil, fmt.Errorf("unexpected opcode %d in external-IP response", resp[1])
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+		return nil, fmt.Errorf("gateway returned result code %d", code)
+	}
+	return net.IP(resp[8:12]), nil
+}
+
+func (p *pmp) ExternalIP() (net.IP, error) {
+	return p.externalIP(discoveryTimeout)
+}
+
+// pmpOpcode maps a mapping protocol to its NAT-PMP request opcode, per
+// RFC 6886 section 3.3 (1 = UDP, 2 = TCP).
+func pmpOpcode(protocol string) (byte, error) {
+	switch strings.ToLower(protocol) {
+	case "udp":
+		return 1, nil
+	case "tcp":
+		return 2, nil
+	default:
+		return 0, fmt.Errorf("unsupported protocol %q for NAT-PMP", protocol)
+	}
+}
+
+func (p *pmp) AddMapping(protocol string, extPort, intPort int, desc string, duration time.Duration) error {
+	op, err := pmpOpcode(protocol)
+	if err != nil {
+		return err
+	}
+
+	req := make([]byte, 12)
+	req[1] = op
+	binary.BigEndian.PutUint16(req[4:6], uint16(intPort))
+	binary.BigEndian.PutUint16(req[6:8], uint16(extPort))
+	binary.BigEndian.PutUint32(req[8:12], uint32(duration.Seconds()))
+
+	resp, err := p.request(req, 16, discoveryTimeout)
+	if err != nil {
+		return err
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+		return fmt.Errorf("mapping request denied, result code %d", code)
+	}
+	return nil
+}
+
+func (p *pmp) DeleteMapping(protocol string, extPort, intPort int) error {
+	// RFC 6886 section 3.4: a mapping is deleted by requesting it again
+	// for the same internal port with a lifetime of zero.
+	op, err := pmpOpcode(protocol)
+	if err != nil {
+		return err
+	}
+
+	req := make([]byte, 12)
+	req[1] = op
+	binary.BigEndian.PutUint16(req[4:6], uint16(intPort))
+
+	_, err = p.request(req, 16, discoveryTimeout)
+	return err
+}
+
+func (p *pmp) String() string { return fmt.Sprintf("NAT-PMP(%v)", p.gw) }
@@ -0,0 +1,246 @@
+package nat
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// upnp implements Interface against a UPnP Internet Gateway Device's
+// WANIPConnection (or WANPPPConnection) SOAP service.
+type upnp struct {
+	service    string
+	controlURL string
+}
+
+const ssdpAddr = "239.255.255.250:1900"
+
+// discoverUPnP finds an IGD by SSDP multicast M-SEARCH and fetches its
+// device description to locate a WAN connection service to call.
+func discoverUPnP(timeout time.Duration) (*upnp, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	req := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(req), dst); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		return nil, fmt.Errorf("no UPnP gateway responded: %w", err)
+	}
+
+	loc, err := ssdpLocation(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	return fetchIGD(loc)
+}
+
+// ssdpLocation extracts the LOCATION header (the device description
+// URL) from an SSDP response.
+func ssdpLocation(resp []byte) (string, error) {
+	for _, line := range strings.Split(string(resp), "\r\n") {
+		idx := strings.IndexByte(line, ':')
+		if idx <= 0 {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(line[:idx]), "LOCATION") {
+			return strings.TrimSpace(line[idx+1:]), nil
+		}
+	}
+	return "", fmt.Errorf("SSDP response missing LOCATION header")
+}
+
+type igdDesc struct {
+	Device igdDevice `xml:"device"`
+}
+
+type igdDevice struct {
+	DeviceList  []igdDevice  `xml:"deviceList>device"`
+	ServiceList []igdService `xml:"serviceList>service"`
+}
+
+type igdService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+func fetchIGD(loc string) (*upnp, error) {
+	resp, err := http.Get(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var desc igdDesc
+	if err := xml.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		return nil, fmt.Errorf("parsing IGD description: %w", err)
+	}
+
+	svc, ok := findWANConnectionService(desc.Device)
+	if !ok {
+		return nil, fmt.Errorf("no WANIPConnection/WANPPPConnection service in IGD description")
+	}
+
+	base, err := url.Parse(loc)
+	if err != nil {
+		return nil, err
+	}
+	control, err := base.Parse(svc.ControlURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upnp{service: svc.ServiceType, controlURL: control.String()}, nil
+}
+
+func findWANConnectionService(d igdDevice) (igdService, bool) {
+	for _, s := range d.ServiceList {
+		if strings.Contains(s.ServiceType, "WANIPConnection") || strings.Contains(s.ServiceType, "WANPPPConnection") {
+			return s, true
+		}
+	}
+	for _, child := range d.DeviceList {
+		if s, ok := findWANConnectionService(child); ok {
+			return s, true
+		}
+	}
+	return igdService{}, false
+}
+
+// soapCall invokes action on the IGD's control URL with args supplied in
+// order, and returns the response's top-level fields.
+func (u *upnp) soapCall(action string, order []string, args map[string]string) (map[string]string, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?>`)
+	body.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, u.service)
+	for _, k := range order {
+		fmt.Fprintf(&body, "<%s>%s</%s>", k, args[k], k)
+	}
+	fmt.Fprintf(&body, `</u:%s>`, action)
+	body.WriteString(`</s:Body></s:Envelope>`)
+
+	req, err := http.NewRequest(http.MethodPost, u.controlURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, u.service, action))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("SOAP call %s failed: %s", action, resp.Status)
+	}
+
+	var env struct {
+		Body struct {
+			Raw []byte `xml:",innerxml"`
+		} `xml:"Body"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return nil, err
+	}
+
+	out := map[string]string{}
+	dec := xml.NewDecoder(bytes.NewReader(env.Body.Raw))
+	var cur string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			cur = t.Name.Local
+		case xml.CharData:
+			if cur != "" {
+				out[cur] = string(t)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (u *upnp) AddMapping(protocol string, extPort, intPort int, desc string, duration time.Duration) error {
+	ip, err := localIP()
+	if err != nil {
+		return err
+	}
+	order := []string{
+		"NewRemoteHost", "NewExternalPort", "NewProtocol", "NewInternalPort",
+		"NewInternalClient", "NewEnabled", "NewPortMappingDescription", "NewLeaseDuration",
+	}
+	_, err = u.soapCall("AddPortMapping", order, map[string]string{
+		"NewRemoteHost":             "",
+		"NewExternalPort":           strconv.Itoa(extPort),
+		"NewProtocol":               strings.ToUpper(protocol),
+		"NewInternalPort":           strconv.Itoa(intPort),
+		"NewInternalClient":         ip.String(),
+		"NewEnabled":                "1",
+		"NewPortMappingDescription": desc,
+		"NewLeaseDuration":          strconv.Itoa(int(duration.Seconds())),
+	})
+	return err
+}
+
+func (u *upnp) DeleteMapping(protocol string, extPort, intPort int) error {
+	order := []string{"NewRemoteHost", "NewExternalPort", "NewProtocol"}
+	_, err := u.soapCall("DeletePortMapping", order, map[string]string{
+		"NewRemoteHost":   "",
+		"NewExternalPort": strconv.Itoa(extPort),
+		"NewProtocol":     strings.ToUpper(protocol),
+	})
+	return err
+}
+
+func (u *upnp) ExternalIP() (net.IP, error) {
+	out, err := u.soapCall("GetExternalIPAddress", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(out["NewExternalIPAddress"])
+	if ip == nil {
+		return nil, fmt.Errorf("gateway returned invalid external IP %q", out["NewExternalIPAddress"])
+	}
+	return ip, nil
+}
+
+func (u *upnp) String() string { return "UPnP IGD " + u.controlURL }
+
+// localIP returns the address this host would use to reach the
+// internet, which is what an IGD expects as NewInternalClient.
+func localIP() (net.IP, error) {
+	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
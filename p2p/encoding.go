@@ -0,0 +1,75 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+)
+
+// Decoder decodes whatever is read off of r into msg.
+type Decoder interface {
+	Decode(r io.Reader, msg *RPC) error
+}
+
+type GOBDecoder struct{}
+
+func (dec GOBDecoder) Decode(r io.Reader, msg *RPC) error {
+	return gob.NewDecoder(r).Decode(msg)
+}
+
+// DefaultDecoder reads a single byte stream marker and, for plain messages,
+// the raw payload bytes that follow. Stream markers (IncomingStream) are
+// left for the caller to consume directly off the connection.
+type DefaultDecoder struct{}
+
+func (dec DefaultDecoder) Decode(r io.Reader, msg *RPC) error {
+	peekBuf := make([]byte, 1)
+	if _, err := r.Read(peekBuf); err != nil {
+		return err
+	}
+
+	// In case of a stream we are not decoding what is being sent over the
+	// network. We are just setting Stream true so we can handle that
+	// logic elsewhere. This way we do not invoke the decoder.
+	if peekBuf[0] == IncomingStream {
+		msg.Stream = true
+		return nil
+	}
+
+	// A framed Msg carries its own code and length, so we can read exactly
+	// one frame and hand the caller a ready-to-decode payload.
+	if peekBuf[0] == IncomingFramedMsg {
+		framed, err := ReadMsg(r)
+		if err != nil {
+			return err
+		}
+		payload, err := io.ReadAll(framed.Payload)
+		if err != nil {
+			return err
+		}
+		msg.IsMsg = true
+		msg.Code = framed.Code
+		msg.Payload = payload
+		return nil
+	}
+
+	// A legacy Message frame is length-prefixed the same way a framed Msg
+	// is: a single r.Read call only promises up to len(buf) bytes, not
+	// "the whole message", so treating whatever came back as the entire
+	// payload truncates anything over the buffer size (or two messages
+	// written back-to-back) and desyncs the connection from then on.
+	lenBuf := make([]byte, 4)
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(lenBuf)
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+
+	msg.Payload = payload
+
+	return nil
+}
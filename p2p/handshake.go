@@ -0,0 +1,8 @@
+package p2p
+
+// HandshakeFunc runs right after a connection is accepted/dialed and before
+// any peer is registered with the transport's OnPeer callback. Returning an
+// error drops the connection.
+type HandshakeFunc func(Peer) error
+
+func NOPHandshakeFunc(Peer) error { return nil }
@@ -0,0 +1,27 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// NodeID uniquely identifies a node across reconnects, independent of
+// whatever address it happens to dial in from (an ephemeral source port
+// makes the same node look like a different peer on every reconnect if
+// identity is keyed by address instead).
+type NodeID string
+
+// NewNodeID generates a random NodeID, for callers that don't have a
+// more durable identity (a persisted keypair, say) to hand.
+func NewNodeID() NodeID {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand.Read only fails if the OS entropy source is broken
+	}
+	return NodeID(hex.EncodeToString(b))
+}
+
+// Cap names an optional capability a node supports, advertised in its
+// handshake so peers can decide whether to rely on a feature before
+// trying it, instead of finding out by a failed request.
+type Cap string
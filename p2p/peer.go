@@ -0,0 +1,40 @@
+package p2p
+
+import "net"
+
+// Peer represents the remote node in a TCP established connection.
+type Peer interface {
+	net.Conn
+	MsgReadWriter
+	Send([]byte) error
+	CloseStream()
+	RemoteAddr() net.Addr
+}
+
+// RPC holds any arbitrary data that is being sent over each
+// transport between two nodes in the network.
+type RPC struct {
+	From    string
+	Payload []byte
+	Stream  bool
+
+	// IsMsg is true when Payload holds the body of a code-multiplexed Msg
+	// (see msg.go) rather than a legacy gob-encoded Message. Code then
+	// identifies which Msg kind Payload decodes to.
+	IsMsg bool
+	Code  uint64
+}
+
+const (
+	// IncomingMessage marks the following frame as a gob-encoded Message.
+	// Kept for compatibility with peers that have not migrated to the
+	// code-multiplexed Msg framing yet.
+	IncomingMessage = 0x1
+	// IncomingStream marks the following bytes as a raw file stream rather
+	// than a Message. The stream carries no length prefix; its length comes
+	// from the control message sent ahead of it.
+	IncomingStream = 0x2
+	// IncomingFramedMsg marks the following bytes as a Msg: an 8 byte
+	// code, a 4 byte payload length, then the payload itself.
+	IncomingFramedMsg = 0x3
+)
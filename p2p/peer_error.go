@@ -0,0 +1,59 @@
+package p2p
+
+import "fmt"
+
+// PeerErrorCode classifies why a peer connection failed, so a read loop
+// (and, eventually, reputation/banning logic) can react to the specific
+// failure mode instead of an opaque error.
+type PeerErrorCode int
+
+const (
+	// ErrProtocolVersion means the peer's handshake advertised a
+	// ProtocolVersion we don't speak.
+	ErrProtocolVersion PeerErrorCode = iota + 1
+	// ErrInvalidMsgCode means a Msg's Code fell outside every registered
+	// subprotocol's range, or a handshake carried an empty NodeID.
+	ErrInvalidMsgCode
+	// ErrPayloadTooLarge means a framed Msg's declared size exceeded
+	// maxMsgSize.
+	ErrPayloadTooLarge
+	// ErrTimeout means the peer did not respond within the time a
+	// protocol step allows it.
+	ErrTimeout
+	// ErrDisconnectRequested means the peer asked to end the connection
+	// cleanly.
+	ErrDisconnectRequested
+)
+
+var peerErrorCodeNames = map[PeerErrorCode]string{
+	ErrProtocolVersion:     "protocol version mismatch",
+	ErrInvalidMsgCode:      "invalid message code",
+	ErrPayloadTooLarge:     "payload too large",
+	ErrTimeout:             "timeout",
+	ErrDisconnectRequested: "disconnect requested",
+}
+
+func (c PeerErrorCode) String() string {
+	if name, ok := peerErrorCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown peer error code %d", int(c))
+}
+
+// PeerError reports a classified peer-protocol failure, as opposed to a
+// plain I/O or decoding error, so callers can tell "this peer broke the
+// protocol" apart from "the connection dropped" and react accordingly.
+type PeerError struct {
+	Code    PeerErrorCode
+	Message string
+}
+
+// NewPeerError builds a PeerError under code, with a message formatted
+// the way fmt.Errorf would.
+func NewPeerError(code PeerErrorCode, format string, args ...any) *PeerError {
+	return &PeerError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *PeerError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
@@ -0,0 +1,80 @@
+package p2p
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// ErrPipeClosed is returned from a PipePeer's Read/Write (and therefore
+// Send/ReadMsg/WriteMsg) once either end of its MsgPipe has been closed.
+// It is net.Pipe's own sentinel; PipePeer.Read maps the io.EOF that
+// net.Pipe returns to a reader whose other end closed onto it as well.
+var ErrPipeClosed = io.ErrClosedPipe
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// PipePeer is the Peer implementation returned by MsgPipe: a net.Pipe
+// connection with the marker-byte/CloseStream conventions the rest of p2p
+// expects, so it is a drop-in stand-in for a TCPPeer in tests.
+type PipePeer struct {
+	net.Conn
+	outbound   bool
+	remoteAddr pipeAddr
+
+	// wg mirrors TCPPeer's stream park/resume convention: a read loop
+	// that sees an IncomingStream marker parks on wg.Wait() until the
+	// stream's reader calls CloseStream.
+	wg sync.WaitGroup
+}
+
+func (p *PipePeer) Send(b []byte) error {
+	_, err := p.Conn.Write(b)
+	return err
+}
+
+func (p *PipePeer) CloseStream() {
+	p.wg.Done()
+}
+
+func (p *PipePeer) RemoteAddr() net.Addr {
+	return p.remoteAddr
+}
+
+// Read reports ErrPipeClosed, rather than net.Pipe's io.EOF, once the other
+// end has been closed, so both ends see the same error.
+func (p *PipePeer) Read(b []byte) (int, error) {
+	n, err := p.Conn.Read(b)
+	if err == io.EOF {
+		err = ErrPipeClosed
+	}
+	return n, err
+}
+
+func (p *PipePeer) ReadMsg() (Msg, error) {
+	return ReadMsg(p)
+}
+
+func (p *PipePeer) WriteMsg(code uint64, val any) error {
+	if err := p.Send([]byte{IncomingFramedMsg}); err != nil {
+		return err
+	}
+	return WriteMsg(p.Conn, code, val)
+}
+
+// MsgPipe returns two connected in-process Peers with no TCP, ports, or
+// sockets involved: anything written on one end is readable on the
+// other, backed by the synchronous, channel-based net.Pipe. Closing
+// either end causes ErrPipeClosed on subsequent reads/writes on both.
+//
+// This exists so FileServer behavior can be driven in tests without TCP
+// ports or sockets.
+func MsgPipe() (Peer, Peer) {
+	connA, connB := net.Pipe()
+	a := &PipePeer{Conn: connA, outbound: true, remoteAddr: "pipe"}
+	b := &PipePeer{Conn: connB, outbound: false, remoteAddr: "pipe"}
+	return a, b
+}
@@ -0,0 +1,131 @@
+package p2p
+
+import (
+	"fmt"
+	"sync"
+)
+
+// PipeNetwork is an in-process registry of PipeTransports. A PipeTransport
+// registers itself by address on construction; Dial looks the target up
+// in the same registry and connects directly via MsgPipe, instead of
+// going over a real socket. Each test (or test cluster) should use its
+// own PipeNetwork so addresses from unrelated tests can't collide.
+type PipeNetwork struct {
+	mu    sync.Mutex
+	peers map[string]*PipeTransport
+}
+
+func NewPipeNetwork() *PipeNetwork {
+	return &PipeNetwork{peers: make(map[string]*PipeTransport)}
+}
+
+func (n *PipeNetwork) register(t *PipeTransport) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.peers[t.addr] = t
+}
+
+func (n *PipeNetwork) lookup(addr string) (*PipeTransport, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	t, ok := n.peers[addr]
+	return t, ok
+}
+
+// PipeTransport implements Transport entirely in memory via MsgPipe, so a
+// cluster of FileServers can be wired together deterministically in
+// tests without TCP, ports, or sleeps.
+type PipeTransport struct {
+	addr    string
+	network *PipeNetwork
+
+	HandshakeFunc HandshakeFunc
+	OnPeer        func(Peer) error
+
+	rpcch     chan RPC
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func NewPipeTransport(network *PipeNetwork, addr string) *PipeTransport {
+	t := &PipeTransport{
+		addr:    addr,
+		network: network,
+		rpcch:   make(chan RPC, 1024),
+		closed:  make(chan struct{}),
+	}
+	network.register(t)
+	return t
+}
+
+func (t *PipeTransport) Addr() string {
+	return t.addr
+}
+
+func (t *PipeTransport) Consume() <-chan RPC {
+	return t.rpcch
+}
+
+func (t *PipeTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
+
+// ListenAndAccept is a no-op: a PipeTransport is reachable for Dial as
+// soon as it is registered with its PipeNetwork in NewPipeTransport.
+func (t *PipeTransport) ListenAndAccept() error {
+	return nil
+}
+
+func (t *PipeTransport) Dial(addr string) error {
+	remote, ok := t.network.lookup(addr)
+	if !ok {
+		return fmt.Errorf("p2p: no pipe transport registered for %q", addr)
+	}
+
+	local, remotePeer := MsgPipe()
+	lp, rp := local.(*PipePeer), remotePeer.(*PipePeer)
+	lp.remoteAddr, lp.outbound = pipeAddr(addr), true
+	rp.remoteAddr, rp.outbound = pipeAddr(t.addr), false
+
+	go t.handlePeer(lp)
+	go remote.handlePeer(rp)
+	return nil
+}
+
+func (t *PipeTransport) handlePeer(peer *PipePeer) {
+	if t.HandshakeFunc != nil {
+		if err := t.HandshakeFunc(peer); err != nil {
+			return
+		}
+	}
+	if t.OnPeer != nil {
+		if err := t.OnPeer(peer); err != nil {
+			return
+		}
+	}
+	t.readLoop(peer)
+}
+
+func (t *PipeTransport) readLoop(peer *PipePeer) {
+	decoder := DefaultDecoder{}
+	for {
+		var rpc RPC
+		if err := decoder.Decode(peer, &rpc); err != nil {
+			return
+		}
+		rpc.From = peer.RemoteAddr().String()
+
+		if rpc.Stream {
+			peer.wg.Add(1)
+			peer.wg.Wait()
+			continue
+		}
+
+		select {
+		case t.rpcch <- rpc:
+		case <-t.closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package p2p
+
+// Message codes for the base file-sharing protocol. A FileServer reserves
+// these via RegisterProtocol before any extension subprotocol, so third
+// party code can add its own message kinds in the codes above
+// BaseProtocolLength without colliding.
+const (
+	StoreFileMsg uint64 = iota
+	GetFileMsg
+	GetFileResponseMsg
+	PingMsg
+	PongMsg
+	HandshakeMsg
+	DisconnectMsg
+)
+
+// BaseProtocolLength is the number of message codes reserved for the base
+// protocol above. Extension subprotocols registered via
+// FileServer.RegisterProtocol are assigned their own, non-overlapping
+// range starting here.
+const BaseProtocolLength uint64 = 16
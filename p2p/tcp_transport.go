@@ -0,0 +1,172 @@
+package p2p
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/ranjankuldeep/distributed_file_system/logs"
+)
+
+// TCPPeer represents the remote node over a TCP established connection.
+type TCPPeer struct {
+	net.Conn
+	// outbound is true if we dialed the connection, false if we accepted it.
+	outbound bool
+
+	wg *sync.WaitGroup
+}
+
+func NewTCPPeer(conn net.Conn, outbound bool) *TCPPeer {
+	return &TCPPeer{
+		Conn:     conn,
+		outbound: outbound,
+		wg:       &sync.WaitGroup{},
+	}
+}
+
+func (p *TCPPeer) Send(b []byte) error {
+	_, err := p.Conn.Write(b)
+	return err
+}
+
+// ReadMsg reads a single framed Msg directly off the connection. Callers
+// are expected to have already consumed the IncomingFramedMsg marker
+// byte, mirroring how a stream's bytes are read directly off the peer
+// after the IncomingStream marker.
+func (p *TCPPeer) ReadMsg() (Msg, error) {
+	return ReadMsg(p.Conn)
+}
+
+// WriteMsg sends the IncomingFramedMsg marker followed by a single framed
+// message encoding val under code.
+func (p *TCPPeer) WriteMsg(code uint64, val any) error {
+	if err := p.Send([]byte{IncomingFramedMsg}); err != nil {
+		return err
+	}
+	return WriteMsg(p.Conn, code, val)
+}
+
+// CloseStream unblocks the read loop for this peer, which is parked via
+// wg.Wait() while a stream (file transfer) is in flight.
+func (p *TCPPeer) CloseStream() {
+	p.wg.Done()
+}
+
+func (p *TCPPeer) RemoteAddr() net.Addr {
+	return p.Conn.RemoteAddr()
+}
+
+type TCPTransportOpts struct {
+	ListenAddr    string
+	HandshakeFunc HandshakeFunc
+	Decoder       Decoder
+	OnPeer        func(Peer) error
+}
+
+type TCPTransport struct {
+	TCPTransportOpts
+	listener net.Listener
+	rpcch    chan RPC
+}
+
+func NewTCPTransport(opts TCPTransportOpts) *TCPTransport {
+	return &TCPTransport{
+		TCPTransportOpts: opts,
+		rpcch:            make(chan RPC, 1024),
+	}
+}
+
+func (t *TCPTransport) Addr() string {
+	return t.ListenAddr
+}
+
+// Consume implements the Transport interface, returning a read-only channel
+// for reading the incoming messages received from another peer.
+func (t *TCPTransport) Consume() <-chan RPC {
+	return t.rpcch
+}
+
+func (t *TCPTransport) Close() error {
+	return t.listener.Close()
+}
+
+func (t *TCPTransport) Dial(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go t.handleConn(conn, true)
+	return nil
+}
+
+func (t *TCPTransport) ListenAndAccept() error {
+	var err error
+	t.listener, err = net.Listen("tcp", t.ListenAddr)
+	if err != nil {
+		return err
+	}
+
+	go t.startAcceptLoop()
+
+	logs.Logger.Infof("TCP transport listening on port: %s\n", t.ListenAddr)
+	return nil
+}
+
+func (t *TCPTransport) startAcceptLoop() {
+	for {
+		conn, err := t.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			logs.Logger.Errorf("TCP accept error: %v\n", err)
+			continue
+		}
+		go t.handleConn(conn, false)
+	}
+}
+
+func (t *TCPTransport) handleConn(conn net.Conn, outbound bool) {
+	var err error
+	defer func() {
+		if err != nil {
+			logs.Logger.Errorf("TCP error: %s\n", err)
+		}
+		conn.Close()
+	}()
+
+	peer := NewTCPPeer(conn, outbound)
+
+	if t.HandshakeFunc != nil {
+		if err = t.HandshakeFunc(peer); err != nil {
+			return
+		}
+	}
+
+	if t.OnPeer != nil {
+		if err = t.OnPeer(peer); err != nil {
+			return
+		}
+	}
+
+	// Read loop
+	rpc := RPC{}
+	for {
+		err = t.Decoder.Decode(conn, &rpc)
+		if err != nil {
+			return
+		}
+		rpc.From = conn.RemoteAddr().String()
+
+		if rpc.Stream {
+			peer.wg.Add(1)
+			logs.Logger.Infof("[%s] incoming stream, waiting...\n", conn.RemoteAddr())
+			peer.wg.Wait()
+			logs.Logger.Infof("[%s] stream closed, resuming read loop\n", conn.RemoteAddr())
+			continue
+		}
+
+		t.rpcch <- rpc
+	}
+}
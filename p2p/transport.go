@@ -0,0 +1,11 @@
+package p2p
+
+// Transport is anything that handles the communication between two nodes
+// in the network. This can be of the form (TCP, UDP, websockets, ...).
+type Transport interface {
+	Addr() string
+	Dial(string) error
+	ListenAndAccept() error
+	Consume() <-chan RPC
+	Close() error
+}
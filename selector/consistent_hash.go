@@ -0,0 +1,92 @@
+// Package selector decides which peers in the network are responsible for
+// a given key, so a FileServer can replicate to and fetch from a bounded
+// subset of the network instead of broadcasting to every connected peer.
+package selector
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// PeerID identifies a peer in the ring. Callers are free to use whatever
+// they already have on hand (FileServer uses each peer's handshake NodeID)
+// as long as it is stable for the lifetime of a peer.
+type PeerID string
+
+// PeerSelector picks the peers responsible for a key.
+type PeerSelector interface {
+	// SetPeers replaces the set of peers the selector ranks over. It is
+	// called whenever the network membership changes.
+	SetPeers(ids []PeerID)
+	// Pick returns up to n peer IDs responsible for key, ordered closest
+	// first. It may return fewer than n if fewer peers are known.
+	Pick(key string, n int) []PeerID
+}
+
+// virtualNodes is the number of ring positions hashed per real peer. More
+// virtual nodes spread keys more evenly across peers at the cost of a
+// larger ring to search.
+const virtualNodes = 100
+
+// ConsistentHash is a PeerSelector backed by a consistent-hash ring, so
+// that adding or removing a peer only reshuffles the keys that hashed near
+// it instead of the whole keyspace.
+type ConsistentHash struct {
+	mu sync.RWMutex
+
+	ring     []uint32
+	ringPeer map[uint32]PeerID
+}
+
+func NewConsistentHash() *ConsistentHash {
+	return &ConsistentHash{ringPeer: make(map[uint32]PeerID)}
+}
+
+func (c *ConsistentHash) SetPeers(ids []PeerID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ring = c.ring[:0]
+	c.ringPeer = make(map[uint32]PeerID, len(ids)*virtualNodes)
+
+	for _, id := range ids {
+		for v := 0; v < virtualNodes; v++ {
+			h := hashKey(string(id) + "#" + strconv.Itoa(v))
+			c.ring = append(c.ring, h)
+			c.ringPeer[h] = id
+		}
+	}
+	sort.Slice(c.ring, func(i, j int) bool { return c.ring[i] < c.ring[j] })
+}
+
+func (c *ConsistentHash) Pick(key string, n int) []PeerID {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.ring) == 0 || n <= 0 {
+		return nil
+	}
+
+	h := hashKey(key)
+	start := sort.Search(len(c.ring), func(i int) bool { return c.ring[i] >= h })
+
+	picked := make([]PeerID, 0, n)
+	seen := make(map[PeerID]bool, n)
+	for i := 0; i < len(c.ring) && len(picked) < n; i++ {
+		id := c.ringPeer[c.ring[(start+i)%len(c.ring)]]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		picked = append(picked, id)
+	}
+	return picked
+}
+
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,112 @@
+// Package store implements the on-disk content store used by a FileServer
+// node. Files are namespaced per owner ID, one directory per ID under Root,
+// and keys are transformed into a nested directory layout to avoid gigantic
+// flat directories.
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const defaultRootFolderName = "distributed_file_system_network"
+
+// PathKey is the result of running a key through a PathTransformFunc: a
+// nested directory path plus the leaf filename the content is written under.
+type PathKey struct {
+	PathName string
+	Filename string
+}
+
+// FullPath joins PathName and Filename back into a single relative path.
+func (p PathKey) FullPath() string {
+	return filepath.Join(p.PathName, p.Filename)
+}
+
+// PathTransformFunc maps a content key to the on-disk layout it should be
+// stored under.
+type PathTransformFunc func(key string) PathKey
+
+// DefaultPathTransformFunc stores the key verbatim as both the folder and
+// the filename, i.e. no sharding.
+func DefaultPathTransformFunc(key string) PathKey {
+	return PathKey{PathName: key, Filename: key}
+}
+
+type StoreOpts struct {
+	// Root is the folder containing all the folders/files of the system.
+	Root              string
+	PathTransformFunc PathTransformFunc
+}
+
+type Store struct {
+	StoreOpts
+}
+
+func NewStore(opts StoreOpts) *Store {
+	if opts.PathTransformFunc == nil {
+		opts.PathTransformFunc = DefaultPathTransformFunc
+	}
+	if len(opts.Root) == 0 {
+		opts.Root = defaultRootFolderName
+	}
+	return &Store{StoreOpts: opts}
+}
+
+func (s *Store) rootPathKey(id string, key string) (string, PathKey) {
+	pathKey := s.PathTransformFunc(key)
+	return filepath.Join(s.Root, id), pathKey
+}
+
+// Has reports whether the given key exists on disk for id.
+func (s *Store) Has(id string, key string) bool {
+	root, pathKey := s.rootPathKey(id, key)
+	_, err := os.Stat(filepath.Join(root, pathKey.FullPath()))
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+// Write streams r to disk under id/key and returns the number of bytes
+// written.
+func (s *Store) Write(id string, key string, r io.Reader) (int64, error) {
+	root, pathKey := s.rootPathKey(id, key)
+	if err := os.MkdirAll(filepath.Join(root, pathKey.PathName), os.ModePerm); err != nil {
+		return 0, err
+	}
+
+	f, err := os.Create(filepath.Join(root, pathKey.FullPath()))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return io.Copy(f, r)
+}
+
+// Read returns the size of the stored file and an io.Reader positioned at
+// its start.
+func (s *Store) Read(id string, key string) (int64, io.Reader, error) {
+	f, size, err := s.Open(id, key)
+	if err != nil {
+		return 0, nil, err
+	}
+	return size, f, nil
+}
+
+// Open returns the raw *os.File backing id/key along with its size, so
+// callers (e.g. a ranged network fetch) can wrap it in an io.SectionReader
+// instead of reading the whole thing.
+func (s *Store) Open(id string, key string) (*os.File, int64, error) {
+	root, pathKey := s.rootPathKey(id, key)
+	f, err := os.Open(filepath.Join(root, pathKey.FullPath()))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}